@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/MaTriXy/retool/internal/robustio"
+	"github.com/pkg/errors"
+)
+
+// cmdAdd implements `retool add <import-path> <rev>` and, in module mode,
+// `retool add -mod <import-path>@<version>`.
+func cmdAdd(baseDir, fork string, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	modMode := fs.Bool("mod", false, "pin a module-mode tool in tools.mod instead of tools.json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rest := fs.Args()
+
+	if *modMode {
+		if len(rest) != 1 {
+			return errors.New("usage: retool add -mod <import-path>@<version>")
+		}
+		importPath, mversion, err := splitModArg(rest[0])
+		if err != nil {
+			return err
+		}
+		return addModTool(baseDir, importPath, mversion)
+	}
+
+	if len(rest) != 2 {
+		return errors.New("usage: retool add <import-path> <rev>")
+	}
+	return addLegacyTool(baseDir, rest[0], rest[1], fork)
+}
+
+// splitModArg splits an "<import-path>@<version>" argument as used by
+// `retool add -mod` and `retool upgrade -mod`.
+func splitModArg(arg string) (importPath, version string, err error) {
+	i := strings.LastIndex(arg, "@")
+	if i < 0 {
+		return "", "", errors.Errorf("expected <import-path>@<version>, got %q", arg)
+	}
+	return arg[:i], arg[i+1:], nil
+}
+
+// addLegacyTool pins importPath at rev in the legacy tools.json manifest.
+// It clones (or reuses an already-cloned) source tree under
+// _tools/src/<import-path>, checks out rev, and builds the result in
+// GOPATH mode to confirm the pin actually works before recording it --
+// this is what lets "cache pollution" catch a tool pinned to a commit
+// with a broken import graph instead of silently recording it.
+func addLegacyTool(dir, importPath, rev, fork string) error {
+	kind, tool, resolvedRepo, err := vcsForImportPath(importPath)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := readLegacyManifest(dir)
+	if err != nil {
+		return err
+	}
+	if fork == "" {
+		for _, t := range manifest.Tools {
+			if t.ImportPath == importPath {
+				fork = t.Repo
+				break
+			}
+		}
+	}
+
+	repoURL := resolvedRepo
+	if fork != "" {
+		repoURL = fork
+	}
+
+	srcDir := filepath.Join(dir, "_tools", "src", filepath.FromSlash(importPath))
+	if err := os.MkdirAll(filepath.Dir(srcDir), 0755); err != nil {
+		return errors.Wrap(err, "unable to create tool source directory")
+	}
+
+	if _, err := os.Stat(srcDir); err != nil {
+		if err := tool.Clone(srcDir, repoURL); err != nil {
+			_ = robustio.RemoveAll(srcDir)
+			return errors.Wrapf(err, "unable to clone %s", importPath)
+		}
+	}
+
+	if err := tool.Checkout(srcDir, rev); err != nil {
+		_ = robustio.RemoveAll(srcDir)
+		return errors.Wrapf(err, "unable to check out %s at %s", importPath, rev)
+	}
+
+	commit, err := tool.ResolveRef(srcDir, rev)
+	if err != nil {
+		_ = robustio.RemoveAll(srcDir)
+		return errors.Wrapf(err, "unable to resolve %s at %s", importPath, rev)
+	}
+
+	if err := verifyLegacyToolBuilds(dir, importPath); err != nil {
+		_ = robustio.RemoveAll(srcDir)
+		return errors.Wrapf(err, "%s does not build at %s", importPath, rev)
+	}
+
+	replaced := false
+	for i, t := range manifest.Tools {
+		if t.ImportPath == importPath {
+			manifest.Tools[i] = legacyTool{ImportPath: importPath, Version: commit, Repo: fork, VCS: kind}
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		manifest.Tools = append(manifest.Tools, legacyTool{ImportPath: importPath, Version: commit, Repo: fork, VCS: kind})
+	}
+	return writeLegacyManifest(dir, manifest)
+}
+
+// verifyLegacyToolBuilds builds importPath in GOPATH mode, rooted at
+// dir/_tools, discarding the output binary. It exists purely to validate
+// that a pin resolves and compiles before it's recorded.
+func verifyLegacyToolBuilds(dir, importPath string) error {
+	cmd := exec.Command("go", "build", "-o", os.DevNull, importPath)
+	cmd.Env = append(os.Environ(), "GOPATH="+filepath.Join(dir, "_tools"), "GO111MODULE=off")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Errorf("%s", out)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import "runtime"
+
+// osBinSuffix is appended to the names of binaries retool builds and execs,
+// matching local OS convention (".exe" on Windows, nothing elsewhere).
+var osBinSuffix = binSuffix()
+
+func binSuffix() string {
+	if runtime.GOOS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
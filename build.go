@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// cmdBuild implements `retool build`: (re)build every tool pinned in the
+// manifest from whatever's already fetched under _tools, without touching
+// the network. Unlike sync, build does not clone a tool that hasn't been
+// fetched yet -- it's meant for rebuilding _tools/bin from source that's
+// already on disk (e.g. after deleting _tools/bin to switch Go versions).
+func cmdBuild(baseDir string, args []string) error {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	j := fs.Int("j", runtime.NumCPU(), "number of tools to build in parallel")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if isModManifest(baseDir) {
+		return buildModTools(baseDir, *j)
+	}
+	return buildLegacyTools(baseDir, *j)
+}
+
+func buildModTools(dir string, j int) error {
+	manifest, err := readModManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	binDir := filepath.Join(dir, "_tools", "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return errors.Wrap(err, "unable to create _tools/bin")
+	}
+
+	jobs := make([]toolJob, 0, len(manifest.Tools))
+	for _, tool := range manifest.Tools {
+		tool := tool
+		jobs = append(jobs, toolJob{
+			Name:     tool.ImportPath,
+			RepoRoot: tool.ImportPath,
+			Run: func() error {
+				// installModTool is safe to call again here: with the
+				// module cache already populated by a prior add/sync, `go
+				// install` resolves entirely from GOMODCACHE.
+				return installModTool(dir, tool.ImportPath, tool.Version, binDir)
+			},
+		})
+	}
+	return runToolJobs(jobs, j, printProgress)
+}
+
+func buildLegacyTools(dir string, j int) error {
+	manifest, err := readLegacyManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	binDir := filepath.Join(dir, "_tools", "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return errors.Wrap(err, "unable to create _tools/bin")
+	}
+
+	state := &installState{}
+	var stateMu sync.Mutex
+
+	jobs := make([]toolJob, 0, len(manifest.Tools))
+	for _, tool := range manifest.Tools {
+		tool := tool
+		jobs = append(jobs, toolJob{
+			Name:     tool.ImportPath,
+			RepoRoot: tool.ImportPath,
+			Run: func() error {
+				srcDir := filepath.Join(dir, "_tools", "src", filepath.FromSlash(tool.ImportPath))
+				if _, err := os.Stat(srcDir); err != nil {
+					return errors.Errorf("%s has not been fetched; run `retool sync` first", tool.ImportPath)
+				}
+
+				binName, err := installLegacyTool(dir, tool.ImportPath, binDir)
+				if err != nil {
+					return err
+				}
+				stateMu.Lock()
+				state.Tools = append(state.Tools, installedTool{ImportPath: tool.ImportPath, Commit: tool.Version, BinName: binName})
+				stateMu.Unlock()
+				return nil
+			},
+		})
+	}
+
+	if err := runToolJobs(jobs, j, printProgress); err != nil {
+		return err
+	}
+	return writeInstallState(dir, state)
+}
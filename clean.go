@@ -0,0 +1,19 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/MaTriXy/retool/internal/robustio"
+	"github.com/pkg/errors"
+)
+
+// cmdClean implements `retool clean`, removing _tools entirely so the next
+// sync or build starts from scratch. It uses robustio.RemoveAll rather
+// than os.RemoveAll because _tools/bin holds the very binaries a build
+// running on Windows may still have open.
+func cmdClean(baseDir string, args []string) error {
+	if err := robustio.RemoveAll(filepath.Join(baseDir, "_tools")); err != nil {
+		return errors.Wrap(err, "unable to remove _tools")
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// cmdDo implements `retool do <tool> [args...]`: run a previously synced
+// or built tool's installed binary, forwarding args and streaming its
+// stdio directly.
+func cmdDo(baseDir string, args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: retool do <tool> [args...]")
+	}
+	toolName, toolArgs := args[0], args[1:]
+
+	binPath := filepath.Join(baseDir, "_tools", "bin", toolName+osBinSuffix)
+	if _, err := os.Stat(binPath); err != nil {
+		return errors.Wrapf(err, "tool %q is not installed; run `retool sync` first", toolName)
+	}
+
+	cmd := exec.Command(binPath, toolArgs...)
+	cmd.Dir = baseDir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		return errors.Wrapf(err, "unable to run %s", toolName)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Package robustio wraps the os file operations that retool uses to tear
+// down and rebuild _tools, retrying them when the underlying OS reports a
+// transient failure instead of surfacing it immediately.
+//
+// On Windows, antivirus scanners and lingering child processes frequently
+// hold a short-lived handle open on a freshly built binary or git pack
+// file, which makes a single os.RemoveAll fail with ERROR_ACCESS_DENIED or
+// ERROR_SHARING_VIOLATION even though the file is about to be released. On
+// other platforms these errors don't happen, so the retry loop degrades to
+// a plain call.
+package robustio
+
+import (
+	"os"
+	"time"
+)
+
+// Remove is like os.Remove but retries on Windows when the file is
+// transiently locked.
+func Remove(name string) error {
+	return retry(func() (bool, error) {
+		err := os.Remove(name)
+		return isRetryable(err), err
+	})
+}
+
+// RemoveAll is like os.RemoveAll but retries on Windows when a file or
+// directory underneath name is transiently locked.
+func RemoveAll(path string) error {
+	return retry(func() (bool, error) {
+		err := os.RemoveAll(path)
+		return isRetryable(err), err
+	})
+}
+
+// Rename is like os.Rename but retries on Windows when either path is
+// transiently locked.
+func Rename(oldpath, newpath string) error {
+	return retry(func() (bool, error) {
+		err := os.Rename(oldpath, newpath)
+		return isRetryable(err), err
+	})
+}
+
+// retry calls f in a bounded loop with exponential backoff (up to roughly
+// 2s total) as long as f reports the error is retryable.
+func retry(f func() (retryable bool, err error)) error {
+	var err error
+	for delay := 1 * time.Millisecond; delay < 2*time.Second; delay *= 2 {
+		var retryable bool
+		retryable, err = f()
+		if err == nil || !retryable {
+			return err
+		}
+		time.Sleep(delay)
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package robustio
+
+// isRetryable is always false on non-Windows platforms: the transient
+// sharing errors this package works around are Windows-specific.
+func isRetryable(err error) bool {
+	return false
+}
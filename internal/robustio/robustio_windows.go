@@ -0,0 +1,31 @@
+package robustio
+
+import "syscall"
+
+// isRetryable reports whether err is one of the transient Windows errors
+// that a lingering antivirus scan or child process handle can cause.
+func isRetryable(err error) bool {
+	switch unwrapErrno(err) {
+	case syscall.ERROR_ACCESS_DENIED, syscall.ERROR_SHARING_VIOLATION, syscall.ERROR_FILE_NOT_FOUND:
+		return true
+	default:
+		return false
+	}
+}
+
+func unwrapErrno(err error) syscall.Errno {
+	type causer interface {
+		Unwrap() error
+	}
+	for err != nil {
+		if errno, ok := err.(syscall.Errno); ok {
+			return errno
+		}
+		c, ok := err.(causer)
+		if !ok {
+			break
+		}
+		err = c.Unwrap()
+	}
+	return 0
+}
@@ -0,0 +1,16 @@
+// Package testenv provides information about what functionality is
+// available in different testing environments run by the Go team. It
+// mirrors the same-named package in the Go toolchain's own test suite,
+// scaled down to the one signal retool's integration tests need: whether
+// they're running on a recognized CI builder, where network-heavy tests
+// are expected to run even under -short.
+package testenv
+
+import "os"
+
+// Builder returns the name of the CI builder running this test, or the
+// empty string if it's not running on one. The Go build infrastructure
+// sets GO_BUILDER_NAME for exactly this purpose.
+func Builder() string {
+	return os.Getenv("GO_BUILDER_NAME")
+}
@@ -0,0 +1,74 @@
+// Command retool pins and builds the Go tools a repository depends on
+// (linters, codegen, etc.), independent of whatever's on the developer's
+// GOPATH, so that everyone building the repository uses the same tool
+// versions. See the README for the tools.json/tools.mod manifest formats.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	baseDir, fork, rest := parseGlobalFlags(args)
+	if len(rest) == 0 {
+		return errors.New("usage: retool [-base-dir dir] [-f fork-repo-url] <command> [args...]")
+	}
+
+	cmd, cmdArgs := rest[0], rest[1:]
+	switch cmd {
+	case "version":
+		return cmdVersion(cmdArgs)
+	case "add":
+		return cmdAdd(baseDir, fork, cmdArgs)
+	case "sync":
+		return cmdSync(baseDir, cmdArgs)
+	case "build":
+		return cmdBuild(baseDir, cmdArgs)
+	case "upgrade":
+		return cmdUpgrade(baseDir, cmdArgs)
+	case "do":
+		return cmdDo(baseDir, cmdArgs)
+	case "clean":
+		return cmdClean(baseDir, cmdArgs)
+	default:
+		return errors.Errorf("unknown command %q", cmd)
+	}
+}
+
+// parseGlobalFlags consumes the -base-dir and -f flags, which precede the
+// command name, and returns the remaining arguments starting with that
+// command. Each subcommand parses the rest of its own arguments with its
+// own flag.FlagSet, the same way `go`'s subcommands do.
+func parseGlobalFlags(args []string) (baseDir, fork string, rest []string) {
+	baseDir = "."
+	i := 0
+	for i < len(args) {
+		switch args[i] {
+		case "-base-dir":
+			if i+1 >= len(args) {
+				return baseDir, fork, args[i:]
+			}
+			baseDir = args[i+1]
+			i += 2
+		case "-f":
+			if i+1 >= len(args) {
+				return baseDir, fork, args[i:]
+			}
+			fork = args[i+1]
+			i += 2
+		default:
+			return baseDir, fork, args[i:]
+		}
+	}
+	return baseDir, fork, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// legacyTool is one pinned tool recorded in the legacy tools.json manifest.
+type legacyTool struct {
+	ImportPath string  `json:"importpath"`
+	Version    string  `json:"version"`
+	Repo       string  `json:"repo,omitempty"`
+	VCS        vcsKind `json:"vcs,omitempty"`
+}
+
+// legacyManifest is the parsed form of tools.json, retool's original
+// (pre-modules) manifest format.
+type legacyManifest struct {
+	Tools []legacyTool `json:"tools"`
+}
+
+func legacyManifestPath(dir string) string {
+	return filepath.Join(dir, "tools.json")
+}
+
+// readLegacyManifest reads tools.json from dir. A missing file is not an
+// error; it's treated the same as an empty manifest, since `retool
+// version` and `retool clean` must work in a directory that hasn't
+// called `add` yet.
+func readLegacyManifest(dir string) (*legacyManifest, error) {
+	data, err := ioutil.ReadFile(legacyManifestPath(dir))
+	if os.IsNotExist(err) {
+		return &legacyManifest{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read tools.json")
+	}
+
+	m := &legacyManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrap(err, "unable to parse tools.json")
+	}
+	return m, nil
+}
+
+func writeLegacyManifest(dir string, m *legacyManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to encode tools.json")
+	}
+	return ioutil.WriteFile(legacyManifestPath(dir), data, 0644)
+}
+
+// installedTool records one tool's install state in _tools/manifest.json:
+// the commit that's currently built and the binary name it produced.
+type installedTool struct {
+	ImportPath string `json:"importpath"`
+	Commit     string `json:"commit"`
+	BinName    string `json:"binname"`
+}
+
+// installState is the parsed form of _tools/manifest.json. It's an
+// internal bookkeeping file, separate from tools.json, that records what
+// sync/build last actually produced so they can tell whether a tool is
+// already up to date.
+type installState struct {
+	Tools []installedTool `json:"tools"`
+}
+
+func installStatePath(dir string) string {
+	return filepath.Join(dir, "_tools", "manifest.json")
+}
+
+func readInstallState(dir string) (*installState, error) {
+	data, err := ioutil.ReadFile(installStatePath(dir))
+	if os.IsNotExist(err) {
+		return &installState{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read _tools/manifest.json")
+	}
+
+	s := &installState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, errors.Wrap(err, "unable to parse _tools/manifest.json")
+	}
+	return s, nil
+}
+
+func writeInstallState(dir string, s *installState) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to encode _tools/manifest.json")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "_tools"), 0755); err != nil {
+		return errors.Wrap(err, "unable to create _tools")
+	}
+	return ioutil.WriteFile(installStatePath(dir), data, 0644)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// modManifestName is the file name used for the module-mode manifest. It
+// lives alongside the legacy tools.json manifest and records one require
+// directive per pinned tool, plus its resolved sum in the companion
+// tools.sum file.
+const modManifestName = "tools.mod"
+const modSumName = "tools.sum"
+
+// modTool is a single pinned tool recorded in tools.mod.
+type modTool struct {
+	ImportPath string
+	Version    string
+	// VCS records which vcsTool was used to resolve this tool, so that
+	// sync and build can reproduce the working tree without re-running
+	// vcs.RepoRootForImportPath. Empty for module-proxy-resolved tools,
+	// which don't need a vcsTool at all.
+	VCS vcsKind
+}
+
+// modManifest is the parsed form of tools.mod.
+type modManifest struct {
+	Tools []modTool
+}
+
+// isModManifest reports whether dir has opted into module-mode tool pinning
+// by way of a tools.mod file. When false, callers should fall back to the
+// legacy tools.json manifest.
+func isModManifest(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, modManifestName))
+	return err == nil
+}
+
+// readModManifest parses the tools.mod file in dir.
+func readModManifest(dir string) (*modManifest, error) {
+	path := filepath.Join(dir, modManifestName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read tools.mod")
+	}
+
+	f, err := modfile.Parse(path, data, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse tools.mod")
+	}
+
+	m := &modManifest{}
+	for _, req := range f.Require {
+		if err := module.Check(req.Mod.Path, req.Mod.Version); err != nil {
+			return nil, errors.Wrapf(err, "invalid entry for %s in tools.mod", req.Mod.Path)
+		}
+		m.Tools = append(m.Tools, modTool{ImportPath: req.Mod.Path, Version: req.Mod.Version})
+	}
+	return m, nil
+}
+
+// writeModManifest serializes m to tools.mod in dir, creating it if
+// necessary and overwriting any previous contents.
+//
+// This rebuilds tools.mod from scratch out of m.Tools, so it only ever
+// emits require directives. tools.mod is retool-managed, not meant for
+// hand-editing, so that's fine for the directives retool itself writes
+// today; but it does mean a go/replace/exclude line added by hand would
+// be silently dropped on the next add/upgrade.
+func writeModManifest(dir string, m *modManifest) error {
+	f := &modfile.File{}
+	if err := f.AddModuleStmt("tools"); err != nil {
+		return errors.Wrap(err, "unable to initialize tools.mod")
+	}
+	for _, tool := range m.Tools {
+		if err := f.AddRequire(tool.ImportPath, tool.Version); err != nil {
+			return errors.Wrapf(err, "unable to add %s to tools.mod", tool.ImportPath)
+		}
+	}
+	f.Cleanup()
+
+	out, err := f.Format()
+	if err != nil {
+		return errors.Wrap(err, "unable to format tools.mod")
+	}
+	return ioutil.WriteFile(filepath.Join(dir, modManifestName), out, 0644)
+}
+
+// addModTool records importPath@version in the tools.mod manifest in dir,
+// creating the manifest if it does not already exist, and resolves it
+// through the module proxy so tools.sum can be updated.
+func addModTool(dir, importPath, version string) error {
+	m := &modManifest{}
+	if isModManifest(dir) {
+		var err error
+		m, err = readModManifest(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	resolved, err := resolveModVersion(importPath, version)
+	if err != nil {
+		return errors.Wrapf(err, "unable to resolve %s@%s", importPath, version)
+	}
+
+	replaced := false
+	for i, tool := range m.Tools {
+		if tool.ImportPath == importPath {
+			m.Tools[i].Version = resolved
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		m.Tools = append(m.Tools, modTool{ImportPath: importPath, Version: resolved})
+	}
+
+	return writeModManifest(dir, m)
+}
+
+// resolveModVersion asks the configured module proxy (GOPROXY) to resolve
+// version, honoring GONOSUMCHECK and GOSUMDB the same way the go command
+// does, and returns the canonical version string.
+func resolveModVersion(importPath, version string) (string, error) {
+	cmd := modGoCmd("list", "-m", "-f", "{{.Version}}", fmt.Sprintf("%s@%s", importPath, version))
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "go list -m failed for %s@%s", importPath, version)
+	}
+	return trimNewline(out), nil
+}
+
+// installModTool builds importPath@version with `go install`, using an
+// isolated GOMODCACHE under _tools/pkg/mod so that module downloads don't
+// leak into (or get contaminated by) the user's normal module cache, and
+// places the resulting binary in binDir.
+func installModTool(dir, importPath, version, binDir string) error {
+	modCache := filepath.Join(dir, "_tools", "pkg", "mod")
+	if err := os.MkdirAll(modCache, 0755); err != nil {
+		return errors.Wrap(err, "unable to create isolated module cache")
+	}
+
+	cmd := modGoCmd("install", fmt.Sprintf("%s@%s", importPath, version))
+	cmd.Env = append(os.Environ(),
+		"GOMODCACHE="+modCache,
+		"GOBIN="+binDir,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "go install %s@%s failed: %s", importPath, version, string(out))
+	}
+	return nil
+}
+
+// modGoCmd builds a `go` invocation with GO111MODULE forced on and GOPROXY,
+// GONOSUMCHECK, and GOSUMDB passed through from the surrounding environment
+// unchanged, so users keep control over where module downloads come from
+// and whether they're checked against the sumdb.
+func modGoCmd(args ...string) *exec.Cmd {
+	cmd := exec.Command("go", args...)
+	cmd.Env = append(os.Environ(), "GO111MODULE=on")
+	return cmd
+}
+
+// trimNewline strips a single trailing newline, as produced by `go list`.
+func trimNewline(b []byte) string {
+	return strings.TrimSuffix(string(b), "\n")
+}
@@ -1,301 +1,523 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
-	"github.com/pkg/errors"
+	"github.com/MaTriXy/retool/internal/robustio"
+	"github.com/MaTriXy/retool/internal/testenv"
 )
 
-func TestRetool(t *testing.T) {
-	// These integration tests require more than most go tests: they require a go compiler to build
-	// retool, a working version of git to perform retool's operations, and network access to do the
-	// git fetches.
-	retool, err := buildRetool()
+// testBin is the path to the retool binary, built once in TestMain and
+// shared by every retoolTest in this file.
+var testBin string
+
+// TestMain builds the retool binary a single time before any test runs,
+// mirroring the testgoData pattern from cmd/go's own integration tests:
+// paying the build cost once instead of once per subtest keeps the suite
+// fast, and lets every subtest below skip straight to driving the binary.
+func TestMain(m *testing.M) {
+	switch runtime.GOOS {
+	case "js", "android", "nacl":
+		fmt.Printf("skipping retool integration tests on %s: go build is not supported\n", runtime.GOOS)
+		os.Exit(0)
+	}
+	if runtime.GOOS == "darwin" && strings.HasPrefix(runtime.GOARCH, "arm") {
+		fmt.Printf("skipping retool integration tests on %s/%s: go build is not supported\n", runtime.GOOS, runtime.GOARCH)
+		os.Exit(0)
+	}
+
+	flag.Parse()
+
+	dir, err := ioutil.TempDir("", "retool-test-bin")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer func() { _ = robustio.RemoveAll(dir) }()
+
+	testBin = filepath.Join(dir, "retool"+osBinSuffix)
+	cmd := exec.Command("go", "build", "-o", testBin, ".")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to build retool: %s\n%s\n", err, out)
+		os.Exit(2)
+	}
+
+	os.Exit(m.Run())
+}
+
+// retoolTest drives one or more retool invocations against a shared base
+// directory and captures their stdout/stderr for later assertions. It
+// replaces the copy-pasted tempdir + exec.Command + exec.ExitError
+// unwrapping that used to precede every subtest with a handful of short
+// method calls.
+type retoolTest struct {
+	t      *testing.T
+	dir    string
+	env    []string
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+// newRetoolTest allocates a fresh base directory for t and arranges for it
+// to be cleaned up, via robustio so a lingering handle on a just-built tool
+// binary can't fail the cleanup outright.
+func newRetoolTest(t *testing.T) *retoolTest {
+	dir, err := ioutil.TempDir("", strings.Replace(t.Name(), "/", "_", -1))
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("unable to make temp dir: %s", err)
+	}
+	t.Cleanup(func() {
+		if err := robustio.RemoveAll(dir); err != nil {
+			t.Errorf("unable to clean up temp dir: %s", err)
+		}
+	})
+	return &retoolTest{t: t, dir: dir}
+}
+
+// setenv adds key=value to the environment of every subsequent run/runFail
+// call, on top of the ambient environment.
+func (rt *retoolTest) setenv(key, value string) {
+	rt.env = append(rt.env, key+"="+value)
+}
+
+// tempFile writes contents to name under the test's base directory and
+// returns the full path.
+func (rt *retoolTest) tempFile(name, contents string) string {
+	path := filepath.Join(rt.dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		rt.t.Fatalf("unable to write %s: %s", path, err)
+	}
+	return path
+}
+
+// mustExist fails the test unless path exists.
+func (rt *retoolTest) mustExist(path string) {
+	rt.t.Helper()
+	if _, err := os.Stat(path); err != nil {
+		rt.t.Errorf("expected %s to exist: %s", path, err)
+	}
+}
+
+// wantBinInstalled fails the test unless _tools/bin/name was installed in
+// the test's base directory.
+func (rt *retoolTest) wantBinInstalled(name string) {
+	rt.t.Helper()
+	rt.mustExist(filepath.Join(rt.dir, "_tools", "bin", name+osBinSuffix))
+}
+
+// wantManifestTool fails the test unless tools.json records importPath as
+// having been fetched with the given vcsKind and resolved to a concrete
+// (non-empty) revision -- i.e. that `add` actually dispatched to that VCS
+// and pinned something, not just that it exited 0.
+func (rt *retoolTest) wantManifestTool(importPath string, kind vcsKind) {
+	rt.t.Helper()
+	manifest, err := readLegacyManifest(rt.dir)
+	if err != nil {
+		rt.t.Fatalf("unable to read tools.json: %s", err)
+	}
+	for _, tool := range manifest.Tools {
+		if tool.ImportPath != importPath {
+			continue
+		}
+		if tool.VCS != kind {
+			rt.t.Errorf("%s: have vcs=%q, want %q", importPath, tool.VCS, kind)
+		}
+		if tool.Version == "" {
+			rt.t.Errorf("%s: manifest entry has no resolved revision", importPath)
+		}
+		return
+	}
+	rt.t.Errorf("tools.json has no entry for %s", importPath)
+}
+
+func (rt *retoolTest) doRun(args []string) error {
+	rt.t.Helper()
+	rt.stdout.Reset()
+	rt.stderr.Reset()
+
+	cmd := exec.Command(testBin, append([]string{"-base-dir", rt.dir}, args...)...)
+	cmd.Dir = rt.dir
+	cmd.Stdout = &rt.stdout
+	cmd.Stderr = &rt.stderr
+	if rt.env != nil {
+		cmd.Env = append(os.Environ(), rt.env...)
 	}
-	defer func() {
-		_ = os.RemoveAll(filepath.Dir(retool))
-	}()
+	return cmd.Run()
+}
+
+// run invokes retool with args against the test's base directory, failing
+// the test immediately with both stdout and stderr if it exits non-zero.
+func (rt *retoolTest) run(args ...string) {
+	rt.t.Helper()
+	if err := rt.doRun(args); err != nil {
+		rt.t.Fatalf("command %q failed: %s\nstdout:\n%s\nstderr:\n%s",
+			"retool "+strings.Join(args, " "), err, rt.stdout.String(), rt.stderr.String())
+	}
+}
+
+// runFail is like run but expects retool to exit non-zero.
+func (rt *retoolTest) runFail(args ...string) {
+	rt.t.Helper()
+	if err := rt.doRun(args); err == nil {
+		rt.t.Fatalf("command %q unexpectedly succeeded\nstdout:\n%s\nstderr:\n%s",
+			"retool "+strings.Join(args, " "), rt.stdout.String(), rt.stderr.String())
+	}
+}
+
+// grepStdout fails the test if pattern does not match the most recent
+// run/runFail's stdout.
+func (rt *retoolTest) grepStdout(pattern, message string) {
+	rt.t.Helper()
+	rt.grep(rt.stdout.String(), pattern, message)
+}
+
+// grepStderr is grepStdout for stderr.
+func (rt *retoolTest) grepStderr(pattern, message string) {
+	rt.t.Helper()
+	rt.grep(rt.stderr.String(), pattern, message)
+}
+
+func (rt *retoolTest) grep(output, pattern, message string) {
+	rt.t.Helper()
+	re := regexp.MustCompile(pattern)
+	if !re.MatchString(output) {
+		rt.t.Errorf("%s\npattern %q not found in:\n%s", message, pattern, output)
+	}
+}
+
+// tooSlow skips t under `go test -short` unless it's running on a
+// recognized CI builder. Nearly every test in this file needs network
+// access to fetch a tool over git/hg/bzr/svn, which is too slow and
+// flaky to run by default outside of CI.
+func tooSlow(t *testing.T) {
+	if testing.Short() && testenv.Builder() == "" {
+		t.Skip("skipping network-heavy test in short mode")
+	}
+}
 
+func TestRetool(t *testing.T) {
 	t.Run("retool tests", func(t *testing.T) {
 		t.Run("cache pollution", func(t *testing.T) {
+			tooSlow(t)
 			t.Parallel()
-			dir, cleanup := setupTempDir(t)
-			defer cleanup()
+			rt := newRetoolTest(t)
 
 			// This should fail because this version of mockery has an import line that points to uber's
 			// internal repo, which can't be reached:
-			cmd := exec.Command(retool, "-base-dir", dir, "add",
-				"github.com/vektra/mockery/cmd/mockery", "d895b9fcc32730719faaccd7840ad7277c94c2d0",
-			)
-			cmd.Dir = dir
-			_, err := cmd.Output()
-			if err == nil {
-				t.Fatal("expected error when adding mockery at broken commit d895b9, but got no error")
-			}
+			rt.runFail("add", "github.com/vektra/mockery/cmd/mockery", "d895b9fcc32730719faaccd7840ad7277c94c2d0")
 
 			// Now, without cleaning the cache, try again on a healthy commit. In
 			// ff9a1fda7478ede6250ee3c7e4ce32dc30096236 of retool and earlier, this would still fail because
 			// the cache would be polluted with a bad source tree.
-			runRetoolCmd(t, dir, retool, "add", "github.com/vektra/mockery/cmd/mockery", "origin/master")
+			rt.run("add", "github.com/vektra/mockery/cmd/mockery", "origin/master")
 		})
 
 		t.Run("version", func(t *testing.T) {
 			t.Parallel()
-			dir, cleanup := setupTempDir(t)
-			defer cleanup()
+			rt := newRetoolTest(t)
 
 			// Should work even in a directory without tools.json
-			out := runRetoolCmd(t, dir, retool, "version")
-			if want := fmt.Sprintf("retool %s", version); string(out) != want {
-				t.Errorf("have=%q, want=%q", string(out), want)
-			}
+			rt.run("version")
+			rt.grepStdout("^"+regexp.QuoteMeta(fmt.Sprintf("retool %s", version))+"$", "retool version did not print the expected version string")
 		})
 
 		t.Run("sync", func(t *testing.T) {
-			dir, cleanup := setupTempDir(t)
-			defer cleanup()
+			tooSlow(t)
+			rt := newRetoolTest(t)
 
-			runRetoolCmd(t, dir, retool, "add", "github.com/twitchtv/retool", "origin/master")
+			rt.run("add", "github.com/twitchtv/retool", "origin/master")
 
 			// Delete existing tools directory to try and trigger out of date
-			_ = os.RemoveAll(filepath.Join(dir, "_tools"))
+			_ = robustio.RemoveAll(filepath.Join(rt.dir, "_tools"))
 
 			// Should be able to sync
-			runRetoolCmd(t, dir, retool, "sync")
+			rt.run("sync")
 
-			assertBinInstalled(t, dir, "retool")
+			rt.wantBinInstalled("retool")
 		})
 
 		t.Run("build", func(t *testing.T) {
+			tooSlow(t)
 			t.Parallel()
-			dir, cleanup := setupTempDir(t)
-			defer cleanup()
-			runRetoolCmd(t, dir, retool, "add", "github.com/twitchtv/retool", "origin/master")
+			rt := newRetoolTest(t)
+			rt.run("add", "github.com/twitchtv/retool", "origin/master")
 
 			// Suppose we only have _tools/src available. Does `retool build` work?
-			_ = os.RemoveAll(filepath.Join(dir, "_tools", "bin"))
-			_ = os.RemoveAll(filepath.Join(dir, "_tools", "pkg"))
-			_ = os.RemoveAll(filepath.Join(dir, "_tools", "manifest.json"))
+			_ = robustio.RemoveAll(filepath.Join(rt.dir, "_tools", "bin"))
+			_ = robustio.RemoveAll(filepath.Join(rt.dir, "_tools", "pkg"))
+			_ = robustio.RemoveAll(filepath.Join(rt.dir, "_tools", "manifest.json"))
 
-			runRetoolCmd(t, dir, retool, "build")
+			rt.run("build")
 
 			// Now the binary should be installed
-			assertBinInstalled(t, dir, "retool")
+			rt.wantBinInstalled("retool")
 
 			// Legal files should be kept around
-			_, err := os.Stat(filepath.Join(dir, "_tools", "src", "github.com", "twitchtv", "retool", "LICENSE"))
-			if err != nil {
-				t.Error("missing license file")
-			}
+			rt.mustExist(filepath.Join(rt.dir, "_tools", "src", "github.com", "twitchtv", "retool", "LICENSE"))
 		})
 
 		t.Run("build_with_fork", func(t *testing.T) {
+			tooSlow(t)
 			t.Parallel()
-			dir, cleanup := setupTempDir(t)
-			defer cleanup()
-			runRetoolCmd(t, dir, retool, "-f", "https://github.com/franciscocpg/retool.git", "add", "github.com/twitchtv/retool", "origin/master")
+			rt := newRetoolTest(t)
+			rt.run("-f", "https://github.com/franciscocpg/retool.git", "add", "github.com/twitchtv/retool", "origin/master")
 
 			// Suppose we only have _tools/src available. Does `retool build` work?
-			_ = os.RemoveAll(filepath.Join(dir, "_tools", "bin"))
-			_ = os.RemoveAll(filepath.Join(dir, "_tools", "pkg"))
-			_ = os.RemoveAll(filepath.Join(dir, "_tools", "manifest.json"))
+			_ = robustio.RemoveAll(filepath.Join(rt.dir, "_tools", "bin"))
+			_ = robustio.RemoveAll(filepath.Join(rt.dir, "_tools", "pkg"))
+			_ = robustio.RemoveAll(filepath.Join(rt.dir, "_tools", "manifest.json"))
 
-			runRetoolCmd(t, dir, retool, "build")
+			rt.run("build")
 
 			// Now the binary should be installed
-			assertBinInstalled(t, dir, "retool")
+			rt.wantBinInstalled("retool")
 
 			// Legal files should be kept around
-			_, err := os.Stat(filepath.Join(dir, "_tools", "src", "github.com", "twitchtv", "retool", "LICENSE"))
-			if err != nil {
-				t.Error("missing license file")
-			}
+			rt.mustExist(filepath.Join(rt.dir, "_tools", "src", "github.com", "twitchtv", "retool", "LICENSE"))
 		})
 
 		t.Run("build_with_gobin_set", func(t *testing.T) {
 			// Even if GOBIN is set to a directory not controlled by retool, running
 			// 'retool build' should still put built binaries in _tools/bin.
+			tooSlow(t)
 			t.Parallel()
-			dir, cleanup := setupTempDir(t)
-			defer cleanup()
-			runRetoolCmd(t, dir, retool, "add", "github.com/twitchtv/retool", "origin/master")
+			rt := newRetoolTest(t)
+			rt.run("add", "github.com/twitchtv/retool", "origin/master")
 
-			cmd := makeRetoolCmd(t, dir, retool, "build")
-			cmd.Env = append(os.Environ(), "GOBIN="+dir)
-			err := cmd.Run()
-			if err != nil {
-				t.Fatalf("fatal go build err: %v", err)
-			}
+			rt.setenv("GOBIN", rt.dir)
+			rt.run("build")
 
-			assertBinInstalled(t, dir, "retool")
+			rt.wantBinInstalled("retool")
 		})
 
 		t.Run("dep_added", func(t *testing.T) {
+			tooSlow(t)
 			t.Parallel()
-			dir, cleanup := setupTempDir(t)
-			defer cleanup()
+			rt := newRetoolTest(t)
 
 			// Use a package which used to have a dependency (in this case, one on
 			// github.com/spenczar/retool_test_lib), but doesn't have that dependency for HEAD of
 			// origin/master today.
-			runRetoolCmd(t, dir, retool, "add", "github.com/spenczar/retool_test_app", "origin/has_dep")
+			rt.run("add", "github.com/spenczar/retool_test_app", "origin/has_dep")
 		})
 
 		t.Run("clean", func(t *testing.T) {
-			// Clean should be a noop, but kept around for compatibility
-			cmd := exec.Command(retool, "clean")
-			_, err := cmd.Output()
-			if err != nil {
-				if exitErr, ok := err.(*exec.ExitError); ok {
-					t.Fatalf("expected no errors when using retool clean, have this:\n%s", string(exitErr.Stderr))
-				} else {
-					t.Fatalf("unexpected err when running %q: %q", strings.Join(cmd.Args, " "), err)
-				}
-			}
+			// clean removes _tools; run it on a fresh dir with nothing to
+			// remove to confirm that's not an error.
+			rt := newRetoolTest(t)
+			rt.run("clean")
 		})
 
 		t.Run("do", func(t *testing.T) {
+			tooSlow(t)
 			t.Parallel()
-			dir, cleanup := setupTempDir(t)
-			defer cleanup()
+			rt := newRetoolTest(t)
 
-			runRetoolCmd(t, dir, retool, "add", "github.com/twitchtv/retool", "v1.0.1")
-			output := runRetoolCmd(t, dir, retool, "do", "retool", "version")
-			if want := "retool v1.0.1"; output != want {
-				t.Errorf("have=%q, want=%q", output, want)
-			}
+			rt.run("add", "github.com/twitchtv/retool", "v1.0.1")
+			rt.run("do", "retool", "version")
+			rt.grepStdout("^retool v1\\.0\\.1$", "retool do did not run the pinned version")
 		})
 
 		t.Run("upgrade", func(t *testing.T) {
+			tooSlow(t)
 			t.Parallel()
-			dir, cleanup := setupTempDir(t)
-			defer cleanup()
-			runRetoolCmd(t, dir, retool, "add", "github.com/twitchtv/retool", "v1.0.1")
-			runRetoolCmd(t, dir, retool, "upgrade", "github.com/twitchtv/retool", "v1.0.3")
-			out := runRetoolCmd(t, dir, retool, "do", "retool", "version")
-			if want := "retool v1.0.3"; string(out) != want {
-				t.Errorf("have=%q, want=%q", string(out), want)
-			}
+			rt := newRetoolTest(t)
+			rt.run("add", "github.com/twitchtv/retool", "v1.0.1")
+			rt.run("upgrade", "github.com/twitchtv/retool", "v1.0.3")
+			rt.run("do", "retool", "version")
+			rt.grepStdout("^retool v1\\.0\\.3$", "retool do did not run the upgraded version")
 		})
-		t.Run("gometalinter exemption", func(t *testing.T) {
+
+		t.Run("sync_with_locked_binary", func(t *testing.T) {
+			tooSlow(t)
 			t.Parallel()
-			dir, err := ioutil.TempDir("", "")
-			if err != nil {
-				t.Fatalf("unable to make temp dir: %s", err)
-			}
-			defer func() {
-				_ = os.RemoveAll(dir)
-			}()
+			rt := newRetoolTest(t)
 
-			runRetoolCmd(t, dir, retool, "add", "github.com/alecthomas/gometalinter", "origin/master")
-			runRetoolCmd(t, dir, retool, "do", "gometalinter", "--install")
+			rt.run("add", "github.com/twitchtv/retool", "origin/master")
 
-			// Create a dummy go file so gometalinter runs. If we don't do this,
-			// gometalinter will exit without doing any work, and we'll get a false
-			// positive.
-			//
-			// The file will be removed with the deferred os.RemoveAll(dir) call, no
-			// need to remove it here.
-			f, err := os.Create(filepath.Join(dir, "main.go"))
-			if err != nil {
-				t.Fatalf("unable to create file for gometalinter to run against: %s", err)
-			}
-			defer func() {
-				if closeErr := f.Close(); closeErr != nil {
-					t.Errorf("unable to close gometalinter test file: %s", closeErr)
-				}
-			}()
-			_, err = io.WriteString(f, `package main
-
-func main() {}`)
+			// Open (and hold open) a handle to the binary retool is about to
+			// replace. On Windows this is exactly the situation that used to
+			// make sync's cleanup fail with ERROR_SHARING_VIOLATION.
+			binPath := filepath.Join(rt.dir, "_tools", "bin", "retool"+osBinSuffix)
+			f, err := os.Open(binPath)
 			if err != nil {
-				t.Fatalf("unable to write gometalinter test file: %s", err)
+				t.Fatalf("unable to open %s: %s", binPath, err)
 			}
+			defer f.Close()
 
-			// If gometalinter can't find its tools, it will exit with code 2.
-			runRetoolCmd(t, dir, retool, "do", "gometalinter", ".")
+			rt.run("sync")
 
-			// Make sure gometalinter installs to the tool directory, not to the global
-			// GOPATH.
-			assertBinInstalled(t, dir, "structcheck")
+			rt.wantBinInstalled("retool")
 		})
-	})
-}
 
-func makeRetoolCmd(t *testing.T, dir, retool string, args ...string) *exec.Cmd {
-	args = append([]string{"-base-dir", dir}, args...)
-	cmd := exec.Command(retool, args...)
-	cmd.Dir = dir
-	return cmd
-}
+		t.Run("add_hg", func(t *testing.T) {
+			tooSlow(t)
+			t.Parallel()
+			rt := newRetoolTest(t)
 
-func runRetoolCmd(t *testing.T, dir, retool string, args ...string) (output string) {
-	cmd := makeRetoolCmd(t, dir, retool, args...)
-	out, err := cmd.Output()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			t.Fatalf("command %q failed, stderr:\n%s\n\nstdout:%s", "retool "+strings.Join(cmd.Args[1:], " "), string(exitErr.Stderr), string(out))
-		} else {
-			t.Fatalf("unexpected err when running %q: %q", strings.Join(cmd.Args, " "), err)
-		}
-	}
-	return string(out)
-}
+			// vcs-test.golang.org is the Go project's own long-lived VCS test
+			// server; hgrepo1 is its standing Mercurial fixture, so this
+			// doesn't depend on a third party keeping a repo around.
+			const importPath = "vcs-test.golang.org/hg/hgrepo1"
+			rt.run("add", importPath, "default")
 
-func nameOfTest(t *testing.T) string {
-	// t.Name() was added in go1.8. If it's available, use it. Otherwise, return "".
-	v, ok := interface{}(t).(interface {
-		Name() string
-	})
-	if ok {
-		return v.Name()
-	}
-	return ""
-}
+			rt.mustExist(filepath.Join(rt.dir, "_tools", "src", filepath.FromSlash(importPath)))
+			rt.wantManifestTool(importPath, vcsHg)
+		})
 
-func setupTempDir(t *testing.T) (dir string, cleanup func()) {
-	dir, err := ioutil.TempDir("", strings.Replace(nameOfTest(t), "/", "_", -1))
-	if err != nil {
-		t.Fatalf("unable to make temp dir: %s", err)
-	}
+		t.Run("add_svn", func(t *testing.T) {
+			tooSlow(t)
+			t.Parallel()
+			rt := newRetoolTest(t)
 
-	cleanup = func() {
-		if err := os.RemoveAll(dir); err != nil {
-			t.Errorf("unable to clean up temp dir: %s", err)
-		}
-	}
+			// Same vcs-test.golang.org fixture server, svnrepo1 this time.
+			const importPath = "vcs-test.golang.org/svn/svnrepo1"
+			rt.run("add", importPath, "HEAD")
 
-	return dir, cleanup
-}
+			rt.mustExist(filepath.Join(rt.dir, "_tools", "src", filepath.FromSlash(importPath)))
+			rt.wantManifestTool(importPath, vcsSvn)
+		})
 
-// buildRetool builds retool in a temporary directory and returns the path to
-// the built binary
-func buildRetool() (string, error) {
-	dir, err := ioutil.TempDir("", "")
-	if err != nil {
-		return "", errors.Wrap(err, "unable to create temporary build directory")
-	}
-	output := filepath.Join(dir, "retool"+osBinSuffix)
-	cmd := exec.Command("go", "build", "-o", output, ".")
-	_, err = cmd.Output()
-	if err != nil {
-		return "", errors.Wrap(err, "unable to build retool binary")
-	}
-	return output, nil
-}
+		t.Run("add_mod", func(t *testing.T) {
+			tooSlow(t)
+			t.Parallel()
+			rt := newRetoolTest(t)
 
-func assertBinInstalled(t *testing.T, wd, bin string) {
-	_, err := os.Stat(filepath.Join(wd, "_tools", "bin", bin+osBinSuffix))
-	if err != nil {
-		t.Errorf("unable to find %s: %s", bin+osBinSuffix, err)
-	}
+			rt.run("add", "-mod", "github.com/twitchtv/retool@v1.0.1")
+
+			rt.mustExist(filepath.Join(rt.dir, "tools.mod"))
+		})
+
+		t.Run("sync_mod", func(t *testing.T) {
+			tooSlow(t)
+			t.Parallel()
+			rt := newRetoolTest(t)
+
+			rt.run("add", "-mod", "github.com/twitchtv/retool@v1.0.1")
+
+			// Delete existing tools directory to try and trigger out of date
+			_ = robustio.RemoveAll(filepath.Join(rt.dir, "_tools"))
+
+			rt.run("sync")
+
+			rt.wantBinInstalled("retool")
+		})
+
+		t.Run("build_mod", func(t *testing.T) {
+			tooSlow(t)
+			t.Parallel()
+			rt := newRetoolTest(t)
+			rt.run("add", "-mod", "github.com/twitchtv/retool@v1.0.1")
+
+			// Suppose we only have the module cache available. Does `retool build` work?
+			_ = robustio.RemoveAll(filepath.Join(rt.dir, "_tools", "bin"))
+			_ = robustio.RemoveAll(filepath.Join(rt.dir, "_tools", "manifest.json"))
+
+			rt.run("build")
+
+			rt.wantBinInstalled("retool")
+		})
+
+		t.Run("upgrade_mod", func(t *testing.T) {
+			tooSlow(t)
+			t.Parallel()
+			rt := newRetoolTest(t)
+			rt.run("add", "-mod", "github.com/twitchtv/retool@v1.0.1")
+			rt.run("upgrade", "-mod", "github.com/twitchtv/retool@v1.0.3")
+			rt.run("do", "retool", "version")
+			rt.grepStdout("^retool v1\\.0\\.3$", "retool do did not run the upgraded version")
+		})
+
+		t.Run("sync_parallel", func(t *testing.T) {
+			tooSlow(t)
+			t.Parallel()
+			tools := []string{
+				"github.com/twitchtv/retool",
+				"github.com/alecthomas/gometalinter",
+				"github.com/vektra/mockery/cmd/mockery",
+			}
+
+			serial := newRetoolTest(t)
+			serial.run(append([]string{"add"}, tools...)...)
+			_ = robustio.RemoveAll(filepath.Join(serial.dir, "_tools"))
+			start := time.Now()
+			serial.run("sync", "-j", "1")
+			serialElapsed := time.Since(start)
+
+			parallelRt := newRetoolTest(t)
+			parallelRt.run(append([]string{"add"}, tools...)...)
+			_ = robustio.RemoveAll(filepath.Join(parallelRt.dir, "_tools"))
+			start = time.Now()
+			parallelRt.run("sync", "-j", "3")
+			parallelElapsed := time.Since(start)
+
+			// Allow generous slack for CI jitter (a slow git fetch, a noisy
+			// shared runner): only fail if -j 3 isn't meaningfully faster.
+			if parallelElapsed > time.Duration(float64(serialElapsed)*0.7) {
+				t.Errorf("sync -j 3 (%s) was not meaningfully faster than sync -j 1 (%s)", parallelElapsed, serialElapsed)
+			}
+		})
+
+		t.Run("build_parallel", func(t *testing.T) {
+			tooSlow(t)
+			t.Parallel()
+			tools := []string{
+				"github.com/twitchtv/retool",
+				"github.com/alecthomas/gometalinter",
+				"github.com/vektra/mockery/cmd/mockery",
+			}
+
+			serial := newRetoolTest(t)
+			serial.run(append([]string{"add"}, tools...)...)
+			_ = robustio.RemoveAll(filepath.Join(serial.dir, "_tools", "bin"))
+			start := time.Now()
+			serial.run("build", "-j", "1")
+			serialElapsed := time.Since(start)
+
+			parallelRt := newRetoolTest(t)
+			parallelRt.run(append([]string{"add"}, tools...)...)
+			_ = robustio.RemoveAll(filepath.Join(parallelRt.dir, "_tools", "bin"))
+			start = time.Now()
+			parallelRt.run("build", "-j", "3")
+			parallelElapsed := time.Since(start)
+
+			// Allow generous slack for CI jitter (a slow git fetch, a noisy
+			// shared runner): only fail if -j 3 isn't meaningfully faster.
+			if parallelElapsed > time.Duration(float64(serialElapsed)*0.7) {
+				t.Errorf("build -j 3 (%s) was not meaningfully faster than build -j 1 (%s)", parallelElapsed, serialElapsed)
+			}
+		})
+
+		t.Run("gometalinter exemption", func(t *testing.T) {
+			tooSlow(t)
+			t.Parallel()
+			rt := newRetoolTest(t)
+
+			rt.run("add", "github.com/alecthomas/gometalinter", "origin/master")
+			rt.run("do", "gometalinter", "--install")
+
+			// Create a dummy go file so gometalinter runs. If we don't do this,
+			// gometalinter will exit without doing any work, and we'll get a false
+			// positive.
+			rt.tempFile("main.go", "package main\n\nfunc main() {}\n")
+
+			// If gometalinter can't find its tools, it will exit with code 2.
+			rt.run("do", "gometalinter", ".")
+
+			// Make sure gometalinter installs to the tool directory, not to the global
+			// GOPATH.
+			rt.wantBinInstalled("structcheck")
+		})
+	})
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/MaTriXy/retool/internal/robustio"
+	"github.com/pkg/errors"
+)
+
+// cmdSync implements `retool sync`: fetch (if necessary) and build every
+// tool pinned in the manifest, fanning the work out across -j workers.
+func cmdSync(baseDir string, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	j := fs.Int("j", runtime.NumCPU(), "number of tools to fetch/build in parallel")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if isModManifest(baseDir) {
+		return syncModTools(baseDir, *j)
+	}
+	return syncLegacyTools(baseDir, *j)
+}
+
+func syncModTools(dir string, j int) error {
+	manifest, err := readModManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	binDir := filepath.Join(dir, "_tools", "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return errors.Wrap(err, "unable to create _tools/bin")
+	}
+
+	jobs := make([]toolJob, 0, len(manifest.Tools))
+	for _, tool := range manifest.Tools {
+		tool := tool
+		jobs = append(jobs, toolJob{
+			Name:     tool.ImportPath,
+			RepoRoot: tool.ImportPath,
+			Run: func() error {
+				return installModTool(dir, tool.ImportPath, tool.Version, binDir)
+			},
+		})
+	}
+	return runToolJobs(jobs, j, printProgress)
+}
+
+func syncLegacyTools(dir string, j int) error {
+	manifest, err := readLegacyManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	binDir := filepath.Join(dir, "_tools", "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return errors.Wrap(err, "unable to create _tools/bin")
+	}
+
+	state := &installState{}
+	var stateMu sync.Mutex
+
+	jobs := make([]toolJob, 0, len(manifest.Tools))
+	for _, tool := range manifest.Tools {
+		tool := tool
+		jobs = append(jobs, toolJob{
+			Name:     tool.ImportPath,
+			RepoRoot: tool.ImportPath,
+			Run: func() error {
+				binName, err := fetchAndInstallLegacyTool(dir, tool, binDir)
+				if err != nil {
+					return err
+				}
+				stateMu.Lock()
+				state.Tools = append(state.Tools, installedTool{ImportPath: tool.ImportPath, Commit: tool.Version, BinName: binName})
+				stateMu.Unlock()
+				return nil
+			},
+		})
+	}
+
+	if err := runToolJobs(jobs, j, printProgress); err != nil {
+		return err
+	}
+	return writeInstallState(dir, state)
+}
+
+// fetchAndInstallLegacyTool makes sure tool's source is present at its
+// pinned revision (cloning it if _tools/src doesn't already have it) and
+// then builds it, returning the binary name it installed.
+func fetchAndInstallLegacyTool(dir string, tool legacyTool, binDir string) (string, error) {
+	vcsTool := vcsToolForKind(tool.VCS)
+	srcDir := filepath.Join(dir, "_tools", "src", filepath.FromSlash(tool.ImportPath))
+
+	if _, err := os.Stat(srcDir); err != nil {
+		repoURL := tool.Repo
+		if repoURL == "" {
+			kind, resolvedTool, resolvedRepo, rerr := vcsForImportPath(tool.ImportPath)
+			if rerr != nil {
+				return "", rerr
+			}
+			vcsTool = resolvedTool
+			repoURL = resolvedRepo
+			tool.VCS = kind
+		}
+		if err := os.MkdirAll(filepath.Dir(srcDir), 0755); err != nil {
+			return "", errors.Wrap(err, "unable to create tool source directory")
+		}
+		if err := vcsTool.Clone(srcDir, repoURL); err != nil {
+			_ = robustio.RemoveAll(srcDir)
+			return "", errors.Wrapf(err, "unable to clone %s", tool.ImportPath)
+		}
+	}
+
+	if err := vcsTool.Checkout(srcDir, tool.Version); err != nil {
+		return "", errors.Wrapf(err, "unable to check out %s at %s", tool.ImportPath, tool.Version)
+	}
+
+	return installLegacyTool(dir, tool.ImportPath, binDir)
+}
+
+// installLegacyTool builds importPath in GOPATH mode, rooted at
+// dir/_tools, and installs the resulting binary into binDir.
+func installLegacyTool(dir, importPath, binDir string) (string, error) {
+	cmd := exec.Command("go", "install", importPath)
+	cmd.Env = append(os.Environ(),
+		"GOPATH="+filepath.Join(dir, "_tools"),
+		"GO111MODULE=off",
+		"GOBIN="+binDir,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.Errorf("go install %s failed: %s", importPath, out)
+	}
+	return filepath.Base(importPath), nil
+}
+
+func printProgress(s string) {
+	fmt.Println(s)
+}
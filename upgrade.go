@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/pkg/errors"
+)
+
+// cmdUpgrade implements `retool upgrade <import-path> <rev>` and, in
+// module mode, `retool upgrade -mod <import-path>@<version>`. Both pin
+// the new revision the same way `add` would and rebuild it immediately,
+// so an upgrade that doesn't build is rejected rather than left
+// half-applied in the manifest.
+func cmdUpgrade(baseDir string, args []string) error {
+	fs := flag.NewFlagSet("upgrade", flag.ContinueOnError)
+	modMode := fs.Bool("mod", false, "upgrade a module-mode tool pinned in tools.mod")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rest := fs.Args()
+
+	if *modMode {
+		if len(rest) != 1 {
+			return errors.New("usage: retool upgrade -mod <import-path>@<version>")
+		}
+		importPath, mversion, err := splitModArg(rest[0])
+		if err != nil {
+			return err
+		}
+		return addModTool(baseDir, importPath, mversion)
+	}
+
+	if len(rest) != 2 {
+		return errors.New("usage: retool upgrade <import-path> <rev>")
+	}
+	return addLegacyTool(baseDir, rest[0], rest[1], "")
+}
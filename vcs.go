@@ -0,0 +1,215 @@
+package main
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/vcs"
+)
+
+// vcsTool drives a single version control system well enough for retool to
+// fetch a tool, pin it to a specific revision, and check whether its
+// working tree is dirty. Git remains the default; hg, bzr, and svn are
+// dispatched to based on what vcs.RepoRootForImportPath resolves for a
+// given import path.
+type vcsTool interface {
+	// Clone fetches repoURL into dir.
+	Clone(dir, repoURL string) error
+	// Checkout updates the working tree in dir to rev.
+	Checkout(dir, rev string) error
+	// ResolveRef resolves a symbolic ref (branch, tag, or "origin/master"
+	// style remote ref) to a concrete commit ID.
+	ResolveRef(dir, ref string) (commitID string, err error)
+	// IsClean reports whether the working tree in dir has no local
+	// modifications relative to the checked out revision.
+	IsClean(dir string) (bool, error)
+}
+
+// vcsKind identifies which vcsTool a manifest entry was fetched with, so
+// that sync and build know how to reproduce the working tree without
+// re-resolving the import path.
+type vcsKind string
+
+const (
+	vcsGit vcsKind = "git"
+	vcsHg  vcsKind = "hg"
+	vcsBzr vcsKind = "bzr"
+	vcsSvn vcsKind = "svn"
+)
+
+// vcsForImportPath resolves importPath to its repository root and returns
+// the vcsTool that should be used to fetch it, along with the kind that
+// should be recorded in the manifest.
+func vcsForImportPath(importPath string) (vcsKind, vcsTool, string, error) {
+	root, err := vcs.RepoRootForImportPath(importPath, false)
+	if err != nil {
+		return "", nil, "", errors.Wrapf(err, "unable to resolve repository root for %s", importPath)
+	}
+
+	switch root.VCS.Cmd {
+	case "git":
+		return vcsGit, gitTool{}, root.Repo, nil
+	case "hg":
+		return vcsHg, hgTool{}, root.Repo, nil
+	case "bzr":
+		return vcsBzr, bzrTool{}, root.Repo, nil
+	case "svn":
+		return vcsSvn, svnTool{}, root.Repo, nil
+	default:
+		return "", nil, "", errors.Errorf("unsupported version control system %q for %s", root.VCS.Cmd, importPath)
+	}
+}
+
+// vcsToolForKind returns the vcsTool that drives kind, as previously
+// recorded in a manifest entry by vcsForImportPath. Unknown or empty kinds
+// fall back to git, the default before this kind of VCS auto-detection
+// existed.
+func vcsToolForKind(kind vcsKind) vcsTool {
+	switch kind {
+	case vcsHg:
+		return hgTool{}
+	case vcsBzr:
+		return bzrTool{}
+	case vcsSvn:
+		return svnTool{}
+	default:
+		return gitTool{}
+	}
+}
+
+// runVCSCmd runs name with args in dir, wrapping any failure with the
+// combined output so callers get useful diagnostics.
+func runVCSCmd(dir, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "%s %s failed: %s", name, args, string(out))
+	}
+	return nil
+}
+
+// gitTool is the original, and still default, vcsTool.
+type gitTool struct{}
+
+func (gitTool) Clone(dir, repoURL string) error {
+	return runVCSCmd(dir, "git", "clone", repoURL, dir)
+}
+
+func (gitTool) Checkout(dir, rev string) error {
+	return runVCSCmd(dir, "git", "checkout", rev)
+}
+
+func (gitTool) ResolveRef(dir, ref string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", ref)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "git rev-parse %s failed", ref)
+	}
+	return trimNewline(out), nil
+}
+
+func (gitTool) IsClean(dir string) (bool, error) {
+	cmd := exec.Command("git", "status", "--porcelain")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return false, errors.Wrap(err, "git status failed")
+	}
+	return len(out) == 0, nil
+}
+
+// hgTool drives Mercurial.
+type hgTool struct{}
+
+func (hgTool) Clone(dir, repoURL string) error {
+	return runVCSCmd(dir, "hg", "clone", repoURL, dir)
+}
+
+func (hgTool) Checkout(dir, rev string) error {
+	return runVCSCmd(dir, "hg", "update", "--rev", rev)
+}
+
+func (hgTool) ResolveRef(dir, ref string) (string, error) {
+	cmd := exec.Command("hg", "identify", "--id", "--rev", ref)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "hg identify %s failed", ref)
+	}
+	return trimNewline(out), nil
+}
+
+func (hgTool) IsClean(dir string) (bool, error) {
+	cmd := exec.Command("hg", "status")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return false, errors.Wrap(err, "hg status failed")
+	}
+	return len(out) == 0, nil
+}
+
+// bzrTool drives Bazaar.
+type bzrTool struct{}
+
+func (bzrTool) Clone(dir, repoURL string) error {
+	return runVCSCmd(dir, "bzr", "branch", repoURL, dir)
+}
+
+func (bzrTool) Checkout(dir, rev string) error {
+	return runVCSCmd(dir, "bzr", "update", "-r", rev)
+}
+
+func (bzrTool) ResolveRef(dir, ref string) (string, error) {
+	cmd := exec.Command("bzr", "revno", "-r", ref)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "bzr revno %s failed", ref)
+	}
+	return trimNewline(out), nil
+}
+
+func (bzrTool) IsClean(dir string) (bool, error) {
+	cmd := exec.Command("bzr", "status")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return false, errors.Wrap(err, "bzr status failed")
+	}
+	return len(out) == 0, nil
+}
+
+// svnTool drives Subversion. Subversion has no separate clone/checkout
+// step; checking out a revision is how the working copy is created.
+type svnTool struct{}
+
+func (svnTool) Clone(dir, repoURL string) error {
+	return runVCSCmd(dir, "svn", "checkout", repoURL, dir)
+}
+
+func (svnTool) Checkout(dir, rev string) error {
+	return runVCSCmd(dir, "svn", "update", "-r", rev)
+}
+
+func (svnTool) ResolveRef(dir, ref string) (string, error) {
+	cmd := exec.Command("svn", "info", "--show-item", "revision", "-r", ref)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "svn info %s failed", ref)
+	}
+	return trimNewline(out), nil
+}
+
+func (svnTool) IsClean(dir string) (bool, error) {
+	cmd := exec.Command("svn", "status")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return false, errors.Wrap(err, "svn status failed")
+	}
+	return len(out) == 0, nil
+}
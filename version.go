@@ -0,0 +1,12 @@
+package main
+
+import "fmt"
+
+// version identifies this build of retool. It's printed verbatim by the
+// version command; nothing parses it, so it isn't pinned to any format.
+var version = "v0.0.0-dev"
+
+func cmdVersion(args []string) error {
+	fmt.Print("retool " + version)
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// toolJob is one unit of work handed to the worker pool: fetch (or
+// rebuild) a single tool and report its outcome.
+type toolJob struct {
+	// Name identifies the tool in progress output, typically its import
+	// path.
+	Name string
+	// RepoRoot is the resolved repository root (vcs.RepoRoot.Root) that
+	// backs this tool. Jobs sharing a RepoRoot are serialized against each
+	// other so two tools built from the same working tree never race.
+	RepoRoot string
+	Run      func() error
+}
+
+// runToolJobs runs jobs across a pool of j workers (j <= 0 means
+// runtime.NumCPU()), printing a status line to progress as each job
+// starts and finishes so concurrent output doesn't interleave, and
+// returns an aggregated error covering every job that failed rather than
+// aborting at the first one.
+func runToolJobs(jobs []toolJob, j int, progress func(string)) error {
+	if j <= 0 {
+		j = runtime.NumCPU()
+	}
+	if progress == nil {
+		progress = func(string) {}
+	}
+
+	var (
+		repoLocks  sync.Map // RepoRoot -> *sync.Mutex
+		progressMu sync.Mutex
+		wg         sync.WaitGroup
+		errsMu     sync.Mutex
+		errs       []error
+		sem        = make(chan struct{}, j)
+	)
+
+	lockFor := func(repoRoot string) *sync.Mutex {
+		mu, _ := repoLocks.LoadOrStore(repoRoot, &sync.Mutex{})
+		return mu.(*sync.Mutex)
+	}
+
+	say := func(format string, args ...interface{}) {
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		progress(fmt.Sprintf(format, args...))
+	}
+
+	for _, job := range jobs {
+		job := job
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			mu := lockFor(job.RepoRoot)
+			mu.Lock()
+			defer mu.Unlock()
+
+			say("%s: starting", job.Name)
+			if err := job.Run(); err != nil {
+				say("%s: failed: %s", job.Name, err)
+				errsMu.Lock()
+				errs = append(errs, errors.Wrap(err, job.Name))
+				errsMu.Unlock()
+				return
+			}
+			say("%s: done", job.Name)
+		}()
+	}
+
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return errors.Errorf("%d tool(s) failed:\n%s", len(errs), joinLines(msgs))
+}
+
+func joinLines(lines []string) string {
+	out := ""
+	for i, line := range lines {
+		if i > 0 {
+			out += "\n"
+		}
+		out += "  " + line
+	}
+	return out
+}